@@ -0,0 +1,357 @@
+// Package arch parses pacman/Arch Linux packages and maintains the repository database
+// files (desc entries, db.tar.gz, files.tar.gz) that pacman expects to find alongside them
+package arch
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// PackageInfo holds the metadata extracted from a package's .PKGINFO file
+type PackageInfo struct {
+	Name      string
+	Base      string
+	Version   string
+	Arch      string
+	Depends   []string
+	Provides  []string
+	Conflicts []string
+	// InstalledSize is the total size of the files the package installs (the sum of the
+	// per-file sizes recorded in .MTREE), i.e. pacman's ISIZE - not the size of the
+	// .pkg.tar.zst archive itself, which is reported separately as CSIZE
+	InstalledSize int64
+	BuildDate     int64
+}
+
+// ParsePKGINFO parses the contents of a .PKGINFO file as found inside a pacman package
+func ParsePKGINFO(r io.Reader) (*PackageInfo, error) {
+	info := &PackageInfo{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " = ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := parts[0], parts[1]
+		switch key {
+		case "pkgname":
+			info.Name = value
+		case "pkgbase":
+			info.Base = value
+		case "pkgver":
+			info.Version = value
+		case "arch":
+			info.Arch = value
+		case "depend":
+			info.Depends = append(info.Depends, value)
+		case "provides":
+			info.Provides = append(info.Provides, value)
+		case "conflict":
+			info.Conflicts = append(info.Conflicts, value)
+		case "builddate":
+			if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+				info.BuildDate = ts
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to scan .PKGINFO: %v", err)
+	}
+	if info.Name == "" || info.Version == "" {
+		return nil, fmt.Errorf(".PKGINFO is missing pkgname or pkgver")
+	}
+	return info, nil
+}
+
+// MTREEEntry describes a single file recorded in a package's .MTREE manifest
+type MTREEEntry struct {
+	Path string
+	Size int64
+	Mode string
+}
+
+// ParseMTREE parses the gzip-compressed mtree manifest embedded in a package as .MTREE
+func ParseMTREE(r io.Reader) ([]MTREEEntry, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open .MTREE gzip stream: %v", err)
+	}
+	defer gz.Close()
+
+	var entries []MTREEEntry
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "./") {
+			continue
+		}
+		fields := strings.Fields(line)
+		entry := MTREEEntry{Path: strings.TrimPrefix(fields[0], "./")}
+		for _, f := range fields[1:] {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "size":
+				if sz, err := strconv.ParseInt(kv[1], 10, 64); err == nil {
+					entry.Size = sz
+				}
+			case "mode":
+				entry.Mode = kv[1]
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to scan .MTREE: %v", err)
+	}
+	return entries, nil
+}
+
+// ExtractPackageInfo decompresses a .pkg.tar.zst package and parses its .PKGINFO and
+// .MTREE entries, filling in InstalledSize from the sum of the per-file sizes .MTREE records
+func ExtractPackageInfo(path string) (*PackageInfo, []MTREEEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to open zstd stream: %v", err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	var info *PackageInfo
+	var files []MTREEEntry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to read package tar: %v", err)
+		}
+		switch hdr.Name {
+		case ".PKGINFO":
+			info, err = ParsePKGINFO(tr)
+			if err != nil {
+				return nil, nil, err
+			}
+		case ".MTREE":
+			files, err = ParseMTREE(tr)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if info == nil {
+		return nil, nil, fmt.Errorf("%s does not contain a .PKGINFO entry", path)
+	}
+	for _, file := range files {
+		info.InstalledSize += file.Size
+	}
+	return info, files, nil
+}
+
+// DBEntry is the repository database record for a single package - its rendered desc
+// file plus, for the companion files database, the list of files it installs
+type DBEntry struct {
+	Desc  string
+	Files []MTREEEntry
+}
+
+// DescEntry renders the pacman repository "desc" record for a package
+func DescEntry(info *PackageInfo, filename string, csize int64, md5sum, sha256sum string) string {
+	var b strings.Builder
+	field := func(name, value string) {
+		if value == "" {
+			return
+		}
+		fmt.Fprintf(&b, "%%%s%%\n%s\n\n", name, value)
+	}
+	listField := func(name string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "%%%s%%\n", name)
+		for _, v := range values {
+			fmt.Fprintf(&b, "%s\n", v)
+		}
+		b.WriteString("\n")
+	}
+	field("FILENAME", filename)
+	field("NAME", info.Name)
+	field("BASE", info.Base)
+	field("VERSION", info.Version)
+	fmt.Fprintf(&b, "%%CSIZE%%\n%d\n\n", csize)
+	fmt.Fprintf(&b, "%%ISIZE%%\n%d\n\n", info.InstalledSize)
+	field("MD5SUM", md5sum)
+	field("SHA256SUM", sha256sum)
+	field("ARCH", info.Arch)
+	fmt.Fprintf(&b, "%%BUILDDATE%%\n%d\n\n", info.BuildDate)
+	listField("DEPENDS", info.Depends)
+	listField("PROVIDES", info.Provides)
+	listField("CONFLICTS", info.Conflicts)
+	return b.String()
+}
+
+// entryName is the directory name pacman uses for a package inside the repository database
+func entryName(desc string) (string, error) {
+	name, version := "", ""
+	lines := strings.Split(desc, "\n")
+	for i, line := range lines {
+		switch line {
+		case "%NAME%":
+			if i+1 < len(lines) {
+				name = lines[i+1]
+			}
+		case "%VERSION%":
+			if i+1 < len(lines) {
+				version = lines[i+1]
+			}
+		}
+	}
+	if name == "" || version == "" {
+		return "", fmt.Errorf("desc entry is missing %%NAME%% or %%VERSION%%")
+	}
+	return name + "-" + version, nil
+}
+
+// BuildDB packages a set of desc entries into a pacman-compatible <repo>.db.tar.gz archive
+func BuildDB(entries map[string]*DBEntry) ([]byte, error) {
+	return buildDB(entries, false)
+}
+
+// BuildFilesDB packages a set of desc entries into a pacman-compatible <repo>.files.tar.gz
+// archive, additionally embedding each package's file list under a "files" record
+func BuildFilesDB(entries map[string]*DBEntry) ([]byte, error) {
+	return buildDB(entries, true)
+}
+
+func buildDB(entries map[string]*DBEntry, includeFiles bool) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for key, entry := range entries {
+		name, err := entryName(entry.Desc)
+		if err != nil {
+			name = key
+		}
+		if err := writeTarFile(tw, name+"/desc", entry.Desc); err != nil {
+			return nil, err
+		}
+		if includeFiles {
+			var files strings.Builder
+			files.WriteString("%FILES%\n")
+			for _, file := range entry.Files {
+				fmt.Fprintf(&files, "%s\n", file.Path)
+			}
+			files.WriteString("\n")
+			if err := writeTarFile(tw, name+"/files", files.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to close package database tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to close package database gzip stream: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTarFile(tw *tar.Writer, name, contents string) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+		return fmt.Errorf("Failed to write tar header for %s: %v", name, err)
+	}
+	if _, err := tw.Write([]byte(contents)); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// ParseDB reads back a <repo>.db.tar.gz or <repo>.files.tar.gz archive into its entries,
+// keyed by "<name>-<version>". A db.tar.gz only ever populates Desc; a files.tar.gz as
+// written by BuildFilesDB carries a desc record too, so it populates both Desc and Files
+func ParseDB(r io.Reader) (map[string]*DBEntry, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open package database gzip stream: %v", err)
+	}
+	defer gz.Close()
+
+	entries := make(map[string]*DBEntry)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read package database tar: %v", err)
+		}
+		dir := strings.SplitN(hdr.Name, "/", 2)[0]
+		switch {
+		case strings.HasSuffix(hdr.Name, "/desc"):
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read desc for %s: %v", dir, err)
+			}
+			dbEntry(entries, dir).Desc = string(data)
+		case strings.HasSuffix(hdr.Name, "/files"):
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read files list for %s: %v", dir, err)
+			}
+			dbEntry(entries, dir).Files = parseFilesSection(string(data))
+		}
+	}
+	return entries, nil
+}
+
+func dbEntry(entries map[string]*DBEntry, dir string) *DBEntry {
+	entry := entries[dir]
+	if entry == nil {
+		entry = &DBEntry{}
+		entries[dir] = entry
+	}
+	return entry
+}
+
+// parseFilesSection parses the body of a "<name>-<version>/files" record back into the
+// file list it was built from by buildDB. Only Path survives the round trip - pacman's own
+// files.tar.gz records paths alone, not sizes or modes
+func parseFilesSection(data string) []MTREEEntry {
+	var files []MTREEEntry
+	inFiles := false
+	for _, line := range strings.Split(data, "\n") {
+		if line == "%FILES%" {
+			inFiles = true
+			continue
+		}
+		if !inFiles || line == "" {
+			continue
+		}
+		files = append(files, MTREEEntry{Path: line})
+	}
+	return files
+}
@@ -0,0 +1,122 @@
+package arch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePKGINFO(t *testing.T) {
+	pkginfo := `# Generated by makepkg
+pkgname = example
+pkgbase = example
+pkgver = 1.2.3-1
+arch = x86_64
+builddate = 1700000000
+depend = glibc
+depend = zlib
+provides = example-lib
+conflict = example-old
+`
+	info, err := ParsePKGINFO(strings.NewReader(pkginfo))
+	if err != nil {
+		t.Fatalf("Failed to parse .PKGINFO: %v", err)
+	}
+	if info.Name != "example" || info.Base != "example" || info.Version != "1.2.3-1" || info.Arch != "x86_64" {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+	if info.BuildDate != 1700000000 {
+		t.Errorf("Unexpected builddate: %d", info.BuildDate)
+	}
+	if len(info.Depends) != 2 || info.Depends[0] != "glibc" || info.Depends[1] != "zlib" {
+		t.Errorf("Unexpected depends: %v", info.Depends)
+	}
+	if len(info.Provides) != 1 || info.Provides[0] != "example-lib" {
+		t.Errorf("Unexpected provides: %v", info.Provides)
+	}
+	if len(info.Conflicts) != 1 || info.Conflicts[0] != "example-old" {
+		t.Errorf("Unexpected conflicts: %v", info.Conflicts)
+	}
+}
+
+func TestParsePKGINFOMissingFields(t *testing.T) {
+	if _, err := ParsePKGINFO(strings.NewReader("pkgname = example\n")); err == nil {
+		t.Errorf("Expected an error for a .PKGINFO missing pkgver")
+	}
+}
+
+func TestDescEntryUsesInstalledSize(t *testing.T) {
+	info := &PackageInfo{Name: "example", Version: "1.2.3-1", Arch: "x86_64", InstalledSize: 4096}
+	desc := DescEntry(info, "example-1.2.3-1-x86_64.pkg.tar.zst", 1024, "d41d8cd98f00b204e9800998ecf8427e", "")
+	if !strings.Contains(desc, "%CSIZE%\n1024\n") {
+		t.Errorf("desc entry is missing the expected CSIZE:\n%s", desc)
+	}
+	if !strings.Contains(desc, "%ISIZE%\n4096\n") {
+		t.Errorf("desc entry is missing the expected ISIZE:\n%s", desc)
+	}
+}
+
+func TestBuildAndParseDB(t *testing.T) {
+	entries := map[string]*DBEntry{
+		"example-1.2.3-1": {
+			Desc: DescEntry(&PackageInfo{Name: "example", Version: "1.2.3-1", InstalledSize: 2048}, "example-1.2.3-1.pkg.tar.zst", 512, "", ""),
+			Files: []MTREEEntry{
+				{Path: "usr/bin/example", Size: 2048, Mode: "0755"},
+			},
+		},
+	}
+
+	db, err := BuildDB(entries)
+	if err != nil {
+		t.Fatalf("Failed to build db: %v", err)
+	}
+	parsed, err := ParseDB(strings.NewReader(string(db)))
+	if err != nil {
+		t.Fatalf("Failed to parse db: %v", err)
+	}
+	entry, ok := parsed["example-1.2.3-1"]
+	if !ok {
+		t.Fatalf("db round-trip is missing the example-1.2.3-1 entry: %+v", parsed)
+	}
+	if entry.Desc != entries["example-1.2.3-1"].Desc {
+		t.Errorf("desc did not round-trip:\ngot:  %q\nwant: %q", entry.Desc, entries["example-1.2.3-1"].Desc)
+	}
+	// BuildDB alone never writes a /files record - that only lives in BuildFilesDB's
+	// output - so a db.tar.gz round trip must come back with no Files
+	if entry.Files != nil {
+		t.Errorf("db.tar.gz round trip should not populate Files, got %+v", entry.Files)
+	}
+}
+
+func TestBuildAndParseFilesDB(t *testing.T) {
+	entries := map[string]*DBEntry{
+		"example-1.2.3-1": {
+			Desc: DescEntry(&PackageInfo{Name: "example", Version: "1.2.3-1", InstalledSize: 2048}, "example-1.2.3-1.pkg.tar.zst", 512, "", ""),
+			Files: []MTREEEntry{
+				{Path: "usr/bin/example", Size: 2048, Mode: "0755"},
+				{Path: "usr/share/doc/example/README", Size: 128, Mode: "0644"},
+			},
+		},
+	}
+
+	filesDB, err := BuildFilesDB(entries)
+	if err != nil {
+		t.Fatalf("Failed to build files db: %v", err)
+	}
+	parsed, err := ParseDB(strings.NewReader(string(filesDB)))
+	if err != nil {
+		t.Fatalf("Failed to parse files db: %v", err)
+	}
+	entry, ok := parsed["example-1.2.3-1"]
+	if !ok {
+		t.Fatalf("files db round-trip is missing the example-1.2.3-1 entry: %+v", parsed)
+	}
+	want := []string{"usr/bin/example", "usr/share/doc/example/README"}
+	if len(entry.Files) != len(want) {
+		t.Fatalf("Files did not round-trip: got %+v, want paths %v", entry.Files, want)
+	}
+	for i, path := range want {
+		if entry.Files[i].Path != path {
+			t.Errorf("Files[%d].Path = %q, want %q", i, entry.Files[i].Path, path)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package gorjun
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/crioto/libgorjun/arch"
+)
+
+// UploadArchPackage parses name, version and dependency metadata out of a .pkg.tar.zst
+// package, uploads the raw package the same way UploadFile does, uploads a detached GPG
+// signature alongside it, and rebuilds repo's pacman database to include it. The database
+// is the one for the arch named in the package's .PKGINFO; pkgArch is used only when
+// .PKGINFO names none
+func (g *GorjunServer) UploadArchPackage(path, repo, pkgArch string) (string, error) {
+	info, files, err := arch.ExtractPackageInfo(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse package: %v", err)
+	}
+	if info.Arch != "" {
+		pkgArch = info.Arch
+	}
+
+	id, err := g.UploadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to upload package: %v", err)
+	}
+
+	sigPath, err := g.signArchPackage(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to sign package: %v", err)
+	}
+	defer os.Remove(sigPath)
+	if _, err := g.UploadFile(sigPath); err != nil {
+		return "", fmt.Errorf("Failed to upload package signature: %v", err)
+	}
+
+	if err := g.publishArchPackage(repo, pkgArch, path, info, files); err != nil {
+		return "", fmt.Errorf("Failed to update %s repository database: %v", repo, err)
+	}
+	return id, nil
+}
+
+// RemoveArchPackage removes the name-version entry from repo's pacman database for pkgArch
+// and pushes the rebuilt db.tar.gz/files.tar.gz back, mirroring the fetch/modify/push shape
+// publishArchPackage uses to add or replace an entry
+func (g *GorjunServer) RemoveArchPackage(repo, name, version, pkgArch string) error {
+	entries, err := g.fetchArchDB(repo, pkgArch)
+	if err != nil {
+		return err
+	}
+	key := name + "-" + version
+	if _, ok := entries[key]; !ok {
+		return fmt.Errorf("Package %s was not found in %s repository database", key, repo)
+	}
+	delete(entries, key)
+	if err := g.pushArchDB(repo, pkgArch, entries); err != nil {
+		return fmt.Errorf("Failed to update %s repository database: %v", repo, err)
+	}
+	return nil
+}
+
+// signArchPackage produces a detached binary OpenPGP signature for path using the same
+// keyring code that SignToken uses, and writes it next to the package as "<path>.sig"
+func (g *GorjunServer) signArchPackage(path string) (string, error) {
+	privateKey, err := g.loadSigningKey()
+	if err != nil {
+		return "", err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open %s: %v", path, err)
+	}
+	defer in.Close()
+
+	sigPath := path + ".sig"
+	out, err := os.Create(sigPath)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create %s: %v", sigPath, err)
+	}
+	defer out.Close()
+
+	if err := openpgp.DetachSign(out, privateKey, in, nil); err != nil {
+		return "", fmt.Errorf("Failed to sign %s: %v", path, err)
+	}
+	return sigPath, nil
+}
+
+// publishArchPackage fetches repo's current pacman database for pkgArch (if any), adds or
+// replaces the desc entry for path, and pushes the rebuilt db.tar.gz/files.tar.gz back
+func (g *GorjunServer) publishArchPackage(repo, pkgArch, path string, info *arch.PackageInfo, files []arch.MTREEEntry) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %v", path, err)
+	}
+	md5sum := fmt.Sprintf("%x", md5.Sum(data))
+	sha256sum := fmt.Sprintf("%x", sha256.Sum256(data))
+	filename := filepath.Base(path)
+	desc := arch.DescEntry(info, filename, int64(len(data)), md5sum, sha256sum)
+
+	entries, err := g.fetchArchDB(repo, pkgArch)
+	if err != nil {
+		return err
+	}
+	entries[info.Name+"-"+info.Version] = &arch.DBEntry{Desc: desc, Files: files}
+	return g.pushArchDB(repo, pkgArch, entries)
+}
+
+// fetchArchDB fetches and merges repo's existing db.tar.gz (for each entry's Desc) and
+// files.tar.gz (for each entry's Files) - db.tar.gz alone never carries file lists, so
+// relying on it by itself would silently drop Files for every package already in the repo
+// as soon as one entry was added or removed
+func (g *GorjunServer) fetchArchDB(repo, pkgArch string) (map[string]*arch.DBEntry, error) {
+	entries, err := g.fetchArchDBFile(repo, pkgArch, repo+".db.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	filesEntries, err := g.fetchArchDBFile(repo, pkgArch, repo+".files.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	for key, filesEntry := range filesEntries {
+		entry := entries[key]
+		if entry == nil {
+			entry = &arch.DBEntry{}
+			entries[key] = entry
+		}
+		entry.Files = filesEntry.Files
+	}
+	return entries, nil
+}
+
+func (g *GorjunServer) fetchArchDBFile(repo, pkgArch, filename string) (map[string]*arch.DBEntry, error) {
+	resp, err := http.Get(fmt.Sprintf("https://%s/kurjun/rest/arch/%s/%s/%s", g.Hostname, repo, pkgArch, filename))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch %s: %v", filename, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return map[string]*arch.DBEntry{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %s: server returned %s", filename, resp.Status)
+	}
+	return arch.ParseDB(resp.Body)
+}
+
+func (g *GorjunServer) pushArchDB(repo, pkgArch string, entries map[string]*arch.DBEntry) error {
+	db, err := arch.BuildDB(entries)
+	if err != nil {
+		return fmt.Errorf("Failed to build %s repository database: %v", repo, err)
+	}
+	filesDB, err := arch.BuildFilesDB(entries)
+	if err != nil {
+		return fmt.Errorf("Failed to build %s files database: %v", repo, err)
+	}
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	if err := writeMultipartFile(w, "db", repo+".db.tar.gz", db); err != nil {
+		return err
+	}
+	if err := writeMultipartFile(w, "files", repo+".files.tar.gz", filesDB); err != nil {
+		return err
+	}
+	fw, err := w.CreateFormField("token")
+	if err != nil {
+		return fmt.Errorf("Failed to create token form field: %v", err)
+	}
+	if _, err := fw.Write([]byte(g.Token)); err != nil {
+		return fmt.Errorf("Failed to write token: %v", err)
+	}
+	w.Close()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s/kurjun/rest/arch/%s/%s", g.Hostname, repo, pkgArch), &b)
+	if err != nil {
+		return fmt.Errorf("Failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to execute HTTP request: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Database update failed. Server returned %s error", res.Status)
+	}
+	return nil
+}
+
+func writeMultipartFile(w *multipart.Writer, field, filename string, data []byte) error {
+	fw, err := w.CreateFormFile(field, filename)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s form field: %v", field, err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		return fmt.Errorf("Failed to write %s contents: %v", field, err)
+	}
+	return nil
+}
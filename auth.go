@@ -11,6 +11,7 @@ import (
 
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/errors"
 	"golang.org/x/crypto/openpgp/packet"
 )
 
@@ -18,10 +19,20 @@ import (
 // and sending it back to server to get user token
 // If passphrase is not empty, PGP will try to decrypt the private key before signing the code
 // if gpgdir is empty, the default ($HOME/.gnupg) will be used
+// This is a thin shim around AuthenticateUserWithProvider for callers happy to keep the
+// passphrase in a plain string
 func (g *GorjunServer) AuthenticateUser(username, email, passphrase, gpgdir string) error {
+	g.Passphrase = passphrase
+	return g.AuthenticateUserWithProvider(username, email, StaticPassphrase(passphrase), gpgdir)
+}
+
+// AuthenticateUserWithProvider behaves like AuthenticateUser but obtains the private key
+// passphrase from p instead of a plaintext field, so it never has to be held in memory by
+// the calling program. p may be nil if the key is not encrypted
+func (g *GorjunServer) AuthenticateUserWithProvider(username, email string, p PassphraseProvider, gpgdir string) error {
 	g.Username = username
 	g.Email = email
-	g.Passphrase = passphrase
+	g.passphraseProvider = p
 	if gpgdir != "" {
 		g.GPGDirectory = gpgdir
 	} else {
@@ -96,49 +107,81 @@ func (g *GorjunServer) getKeyByEmail(keyring openpgp.EntityList, email string) *
 	return nil
 }
 
-// SignToken will sign with GnuPG provided token and return signed version
-func (g *GorjunServer) SignToken(token string) (string, error) {
+// loadSigningKey opens the configured GPG directory and returns the private key entity
+// matching g.Email, decrypting it with decryptPrivateKey if it is encrypted. It is shared by
+// SignToken and anything else in the package that needs to produce a detached signature
+func (g *GorjunServer) loadSigningKey() (*openpgp.Entity, error) {
 	if g.GPGDirectory == "" {
-		return "", fmt.Errorf("GPG Directory was not specified")
+		return nil, fmt.Errorf("GPG Directory was not specified")
 	}
-	// GPG may have two variants of key storage - in secring.gpg/pubring.gpg for older versions
-	// and for pubring.kbx and separate directory for private key in version of GnuPG 2.1+
-	pubringPath := g.GPGDirectory + "/pubring.gpg"
-	if _, err := os.Stat(pubringPath); os.IsNotExist(err) {
-		pubringPath = g.GPGDirectory + "/pubring.kbx"
+	keyring, err := LoadKeyring(g.GPGDirectory)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := os.Stat(pubringPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("Can't find pubring.gpg nor pubring.kbx")
+	privateKey := g.getKeyByEmail(keyring, g.Email)
+	if privateKey == nil {
+		return nil, fmt.Errorf("Key for %s was not found", g.Email)
 	}
-	pukFile, err := os.Open(g.GPGDirectory + "/pubring.gpg")
-	defer pukFile.Close()
-	if err != nil {
-		return "", fmt.Errorf("Failed to open public keyring file: %v", err)
+	if privateKey.PrivateKey == nil {
+		return nil, fmt.Errorf("Private key for %s was not found", g.Email)
 	}
-	pubring, err := openpgp.ReadKeyRing(pukFile)
-	if err != nil {
-		return "", fmt.Errorf("Failed to read public keyring: %v", err)
+	if privateKey.PrivateKey.Encrypted {
+		if err := g.decryptPrivateKey(privateKey.PrivateKey); err != nil {
+			return nil, err
+		}
 	}
-	publicKey := g.getKeyByEmail(pubring, g.Email)
-	if publicKey == nil {
-		return "", fmt.Errorf("Public key for %s was not found", g.Email)
+	return privateKey, nil
+}
+
+// decryptPrivateKey decrypts priv, trying g.Passphrase first when it is set and falling
+// back to g.passphraseProvider when g.Passphrase is empty or that attempt fails with
+// ErrKeyIncorrect - this lets a PromptTTY or GPGAgent provider be configured even when the
+// simple case (the passphrase was supplied directly) never has to touch it
+func (g *GorjunServer) decryptPrivateKey(priv *packet.PrivateKey) error {
+	// capture this before a keybox secret overwrites *priv below - it must be the real
+	// public key's original creation time, since the fingerprint/key ID the reconstructed
+	// private key needs to match are computed over it
+	creationTime := priv.PublicKey.CreationTime
+
+	tryPassphrase := func(passphrase string) error {
+		// keys loaded from private-keys-v1.d aren't OpenPGP packets and can't be
+		// decrypted by packet.PrivateKey.Decrypt - unwrap and decrypt them ourselves
+		if pk, ok := getKeyboxSecret(priv); ok {
+			decrypted, err := pk.decrypt(passphrase, creationTime)
+			if err != nil {
+				return err
+			}
+			*priv = *decrypted
+			return nil
+		}
+		return priv.Decrypt([]byte(passphrase))
 	}
 
-	priFile, err := os.Open(g.GPGDirectory + "/secring.gpg")
-	defer priFile.Close()
-	if err != nil {
-		return "", fmt.Errorf("Failed to open private keyring file: %v", err)
+	var err error
+	if g.Passphrase != "" {
+		err = tryPassphrase(g.Passphrase)
+	} else {
+		err = errors.ErrKeyIncorrect
 	}
-	secring, err := openpgp.ReadKeyRing(priFile)
-	if err != nil {
-		return "", fmt.Errorf("Failed to read private keyring: %v", err)
+	if err == errors.ErrKeyIncorrect && g.passphraseProvider != nil {
+		cacheID := fmt.Sprintf("%X", priv.Fingerprint)
+		passphrase, perr := g.passphraseProvider.GetPassphrase(cacheID, fmt.Sprintf("Passphrase for %s", g.Email))
+		if perr != nil {
+			return fmt.Errorf("Failed to obtain passphrase: %v", perr)
+		}
+		err = tryPassphrase(passphrase)
 	}
-	privateKey := g.getKeyByEmail(secring, g.Email)
-	if privateKey == nil {
-		return "", fmt.Errorf("Private key for %s was not found", g.Email)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt private key: %v", err)
 	}
-	if g.Passphrase != "" {
-		privateKey.PrivateKey.Decrypt([]byte(g.Passphrase))
+	return nil
+}
+
+// SignToken will sign with GnuPG provided token and return signed version
+func (g *GorjunServer) SignToken(token string) (string, error) {
+	privateKey, err := g.loadSigningKey()
+	if err != nil {
+		return "", err
 	}
 	outBuf := new(bytes.Buffer)
 	err = openpgp.ArmoredDetachSign(outBuf, privateKey, strings.NewReader(token), nil)
@@ -0,0 +1,318 @@
+package gorjun
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// DownloadOptions controls optional behavior of DownloadFileWithOptions and
+// DownloadFileByIDWithOptions
+type DownloadOptions struct {
+	// Progress, if set, is called as the download proceeds with the number of bytes
+	// written so far and the total file size as reported by the server
+	Progress func(written, total int64)
+}
+
+// HashMismatchError is returned when a downloaded file fails checksum verification
+// for one of the algorithms reported by the server
+type HashMismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// DownloadFile will download file with specified name into the specified output directory
+func (g *GorjunServer) DownloadFile(filename, outputDirectory string) error {
+	return g.DownloadFileWithOptions(filename, outputDirectory, nil)
+}
+
+// DownloadFileByID will download file with specified ID into the specified output directory
+func (g *GorjunServer) DownloadFileByID(ID, outputDirectory string) error {
+	return g.DownloadFileByIDWithOptions(ID, outputDirectory, nil)
+}
+
+// DownloadFileWithOptions behaves like DownloadFile but accepts DownloadOptions to control
+// progress reporting. opts may be nil.
+func (g *GorjunServer) DownloadFileWithOptions(filename, outputDirectory string, opts *DownloadOptions) error {
+	files, err := g.GetFileByName(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to get file: %v", err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%s not found", filename)
+	}
+	return g.downloadFile(files[len(files)-1], outputDirectory, opts)
+}
+
+// DownloadFileByIDWithOptions behaves like DownloadFileByID but accepts DownloadOptions to
+// control progress reporting. opts may be nil.
+func (g *GorjunServer) DownloadFileByIDWithOptions(ID, outputDirectory string, opts *DownloadOptions) error {
+	file, err := g.getFileByID(ID)
+	if err != nil {
+		return err
+	}
+	return g.downloadFile(*file, outputDirectory, opts)
+}
+
+// getFileByID returns information about the file with the specified ID
+func (g *GorjunServer) getFileByID(ID string) (*GorjunFile, error) {
+	resp, err := http.Get(fmt.Sprintf("https://%s/kurjun/rest/raw/info?id=%s", g.Hostname, ID))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve file information from %s: %v", g.Hostname, err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read body from %s: %v", g.Hostname, err)
+	}
+	var files []GorjunFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal contents from %s: %v", g.Hostname, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("file with id %s not found", ID)
+	}
+	return &files[0], nil
+}
+
+// fileHasher accumulates a running checksum for one of the algorithms reported in a
+// GorjunFileHash and verifies it once the download is complete
+type fileHasher struct {
+	algorithm string
+	expected  string
+	hash      hash.Hash
+}
+
+func (h *fileHasher) verify() error {
+	actual := hex.EncodeToString(h.hash.Sum(nil))
+	if actual != h.expected {
+		return &HashMismatchError{Algorithm: h.algorithm, Expected: h.expected, Actual: actual}
+	}
+	return nil
+}
+
+func newFileHashers(h GorjunFileHash) []*fileHasher {
+	var hashers []*fileHasher
+	if h.MD5 != "" {
+		hashers = append(hashers, &fileHasher{"md5", h.MD5, md5.New()})
+	}
+	if h.SHA != "" {
+		hashers = append(hashers, &fileHasher{"sha1", h.SHA, sha1.New()})
+	}
+	if h.SHA256 != "" {
+		hashers = append(hashers, &fileHasher{"sha256", h.SHA256, sha256.New()})
+	}
+	if h.SHA512 != "" {
+		hashers = append(hashers, &fileHasher{"sha512", h.SHA512, sha512.New()})
+	}
+	if h.BLAKE2b256 != "" {
+		if b2, err := blake2b.New256(nil); err == nil {
+			hashers = append(hashers, &fileHasher{"blake2b256", h.BLAKE2b256, b2})
+		}
+	}
+	return hashers
+}
+
+// progressReader wraps an io.Reader and reports cumulative bytes read through a callback
+type progressReader struct {
+	r        io.Reader
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.written += int64(n)
+		p.progress(p.written, p.total)
+	}
+	return n, err
+}
+
+// downloadFile streams file to outputDirectory, verifying every checksum present in
+// file.Hash, resuming from a partial ".part" file if one already exists. A .part file that
+// turns out to be stale - either it fails verification after a resumed download, or the
+// server refuses to resume it at all (commonly a 416 for one that was already complete) -
+// is discarded and the download restarts from scratch instead of wedging on it forever.
+func (g *GorjunServer) downloadFile(file GorjunFile, outputDirectory string, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	partPath := filepath.Join(outputDirectory, file.Name+".part")
+	finalPath := filepath.Join(outputDirectory, file.Name)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		var startOffset int64
+		if info, err := os.Stat(partPath); err == nil {
+			startOffset = info.Size()
+		}
+
+		if startOffset > 0 && startOffset >= int64(file.Size) {
+			// the .part file already covers the whole download, e.g. a crash between
+			// io.Copy finishing and the final rename on a previous attempt - verify and
+			// rename it directly rather than issuing a Range request past the end of
+			// the file, which a real server commonly answers with a 416
+			if err := verifyExistingPart(file, partPath); err == nil {
+				return os.Rename(partPath, finalPath)
+			}
+			os.Remove(partPath)
+			continue
+		}
+
+		retry, err := g.fetchPart(file, partPath, startOffset, opts)
+		if retry {
+			os.Remove(partPath)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := os.Rename(partPath, finalPath); err != nil {
+			return fmt.Errorf("Failed to rename %s to %s: %v", partPath, finalPath, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("Failed to download %s: .part file repeatedly failed verification", file.Name)
+}
+
+// fetchPart issues the GET for file, resuming from startOffset via a Range header when
+// startOffset is non-zero, and streams the response body into partPath. It reports
+// retry=true when the caller should discard partPath and call fetchPart again from
+// scratch: either the server rejected the Range request (416), or the resumed bytes failed
+// checksum verification once the download completed
+func (g *GorjunServer) fetchPart(file GorjunFile, partPath string, startOffset int64, opts *DownloadOptions) (retry bool, err error) {
+	resumed := startOffset > 0
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/kurjun/rest/raw/get?id=%s", g.Hostname, file.Id), nil)
+	if err != nil {
+		return false, fmt.Errorf("Failed to create HTTP request: %v", err)
+	}
+	if resumed {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", startOffset))
+	}
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("Failed to download file: %v", err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case resumed && res.StatusCode == http.StatusPartialContent:
+		// server honored the Range request, keep the bytes already on disk
+	case res.StatusCode == http.StatusOK:
+		// server does not support resume (or there was nothing to resume), start over
+		startOffset, resumed = 0, false
+	case resumed && res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the server rejected resuming from startOffset, commonly because the .part we
+		// had was already complete - let the caller discard it and restart from scratch
+		return true, nil
+	default:
+		return false, fmt.Errorf("Download failed. Server returned %s error", res.Status)
+	}
+
+	hashers := newFileHashers(file.Hash)
+	if resumed {
+		if err := seedHashers(hashers, partPath); err != nil {
+			return false, err
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if resumed {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return false, fmt.Errorf("Failed to open %s: %v", partPath, err)
+	}
+
+	writers := make([]io.Writer, 0, len(hashers)+1)
+	for _, h := range hashers {
+		writers = append(writers, h.hash)
+	}
+	writers = append(writers, out)
+
+	var reader io.Reader = res.Body
+	if opts.Progress != nil {
+		opts.Progress(startOffset, int64(file.Size))
+		reader = &progressReader{r: res.Body, written: startOffset, total: int64(file.Size), progress: opts.Progress}
+	}
+
+	_, err = io.Copy(io.MultiWriter(writers...), reader)
+	closeErr := out.Close()
+	if err != nil {
+		return false, fmt.Errorf("Failed to write file contents: %v", err)
+	}
+	if closeErr != nil {
+		return false, fmt.Errorf("Failed to close %s: %v", partPath, closeErr)
+	}
+
+	for _, h := range hashers {
+		if err := h.verify(); err != nil {
+			if resumed {
+				// the resumed bytes didn't check out, e.g. a corrupted .part left over
+				// from an earlier run - let the caller discard it and restart from
+				// scratch instead of failing the same way on every future call
+				return true, nil
+			}
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// verifyExistingPart checks a .part file that already covers the whole of file against
+// every checksum in file.Hash, without touching the network
+func verifyExistingPart(file GorjunFile, partPath string) error {
+	hashers := newFileHashers(file.Hash)
+	if err := seedHashers(hashers, partPath); err != nil {
+		return err
+	}
+	for _, h := range hashers {
+		if err := h.verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedHashers replays the bytes already present in partPath through hashers so that a
+// resumed download still produces a checksum over the whole file
+func seedHashers(hashers []*fileHasher, partPath string) error {
+	existing, err := os.Open(partPath)
+	if err != nil {
+		return fmt.Errorf("Failed to open existing %s: %v", partPath, err)
+	}
+	defer existing.Close()
+
+	writers := make([]io.Writer, 0, len(hashers))
+	for _, h := range hashers {
+		writers = append(writers, h.hash)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), existing); err != nil {
+		return fmt.Errorf("Failed to seed checksum from %s: %v", partPath, err)
+	}
+	return nil
+}
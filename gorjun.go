@@ -20,13 +20,19 @@ type GorjunServer struct {
 	GPGDirectory string // GPGDirectory points to a gnupg directory in the file system
 	Token        string // Active token
 	TokenCode    string // Clean token code
-	Passphrase   string // Passphrase used to decrypt private key
+	Passphrase   string // Passphrase used to decrypt private key. Shim around StaticPassphrase - prefer AuthenticateUserWithProvider for new code
+
+	passphraseProvider PassphraseProvider // set by AuthenticateUserWithProvider
 }
 
-// GorjunFileHash contents different types of file hashed
+// GorjunFileHash contents different types of file hashed. Every field is optional -
+// a server only fills in the algorithms it actually computed for a given file
 type GorjunFileHash struct {
-	MD5 string `json:"md5"`
-	SHA string `json:"sha"`
+	MD5        string `json:"md5"`
+	SHA        string `json:"sha"`
+	SHA256     string `json:"sha256"`
+	SHA512     string `json:"sha512"`
+	BLAKE2b256 string `json:"blake2b256"`
 }
 
 // GorjunFile is a file located on Gorjun bucket server
@@ -149,13 +155,3 @@ func (g *GorjunServer) RemoveFileByID(ID string) error {
 	}
 	return nil
 }
-
-// DownloadFile will download file with specified name into the specified output directory
-func (g *GorjunServer) DownloadFile(filename, outputDirectory string) error {
-	return nil
-}
-
-// DownloadFileByID will download file with specified ID into the specified output directory
-func (g *GorjunServer) DownloadFileByID(ID, outputDirectory string) error {
-	return nil
-}
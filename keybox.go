@@ -0,0 +1,47 @@
+package gorjun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// kbxOpenPGPBlobType is the blob type GnuPG uses in pubring.kbx for OpenPGP key material,
+// as opposed to type 3 (X.509 certificates)
+const kbxOpenPGPBlobType = 2
+
+// ParseKeybox extracts the raw OpenPGP packet stream embedded in a GnuPG 2.1+ pubring.kbx
+// container so it can be handed to openpgp.ReadKeyRing. A .kbx file is a sequence of
+// length-prefixed blobs; each OpenPGP blob carries the offset and length of the keyblock
+// (the same packets that would otherwise live directly in pubring.gpg) within its own body
+func ParseKeybox(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read keybox: %v", err)
+	}
+	if len(data) < 32 || !bytes.Contains(data[:32], []byte("KBXf")) {
+		return nil, fmt.Errorf("not a GnuPG keybox file (missing KBXf magic)")
+	}
+
+	var packets bytes.Buffer
+	offset := 0
+	for offset+8 <= len(data) {
+		blobLen := binary.BigEndian.Uint32(data[offset : offset+4])
+		if blobLen == 0 || offset+int(blobLen) > len(data) {
+			break
+		}
+		blob := data[offset : offset+int(blobLen)]
+		blobType := blob[4]
+		if blobType == kbxOpenPGPBlobType && len(blob) >= 16 {
+			kbOffset := binary.BigEndian.Uint32(blob[8:12])
+			kbLength := binary.BigEndian.Uint32(blob[12:16])
+			if int(kbOffset)+int(kbLength) <= len(blob) {
+				packets.Write(blob[kbOffset : kbOffset+kbLength])
+			}
+		}
+		offset += int(blobLen)
+	}
+	return packets.Bytes(), nil
+}
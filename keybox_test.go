@@ -0,0 +1,97 @@
+package gorjun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// buildTestKeybox assembles a minimal but structurally valid .kbx file: a header blob
+// carrying the KBXf magic, followed by one blob per entry in blobs. Each entry's packets
+// are embedded in its blob body at the kbOffset/kbLength ParseKeybox is expected to follow
+func buildTestKeybox(t *testing.T, blobs []struct {
+	blobType byte
+	packets  []byte
+}) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	header := make([]byte, 32)
+	binary.BigEndian.PutUint32(header[0:4], 32)
+	copy(header[4:], "KBXf")
+	out.Write(header)
+
+	for _, b := range blobs {
+		const kbOffset = 16
+		blob := make([]byte, kbOffset+len(b.packets))
+		blob[4] = b.blobType
+		binary.BigEndian.PutUint32(blob[8:12], kbOffset)
+		binary.BigEndian.PutUint32(blob[12:16], uint32(len(b.packets)))
+		copy(blob[kbOffset:], b.packets)
+		binary.BigEndian.PutUint32(blob[0:4], uint32(len(blob)))
+		out.Write(blob)
+	}
+	return out.Bytes()
+}
+
+func TestParseKeybox(t *testing.T) {
+	packets := []byte("fake openpgp packet stream")
+	data := buildTestKeybox(t, []struct {
+		blobType byte
+		packets  []byte
+	}{
+		{blobType: kbxOpenPGPBlobType, packets: packets},
+	})
+
+	got, err := ParseKeybox(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseKeybox failed: %v", err)
+	}
+	if !bytes.Equal(got, packets) {
+		t.Errorf("ParseKeybox = %q, want %q", got, packets)
+	}
+}
+
+func TestParseKeyboxConcatenatesMultipleBlobs(t *testing.T) {
+	first, second := []byte("first packet stream"), []byte("second packet stream")
+	data := buildTestKeybox(t, []struct {
+		blobType byte
+		packets  []byte
+	}{
+		{blobType: kbxOpenPGPBlobType, packets: first},
+		{blobType: kbxOpenPGPBlobType, packets: second},
+	})
+
+	got, err := ParseKeybox(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseKeybox failed: %v", err)
+	}
+	want := append(append([]byte{}, first...), second...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("ParseKeybox = %q, want %q", got, want)
+	}
+}
+
+func TestParseKeyboxSkipsNonOpenPGPBlobs(t *testing.T) {
+	x509 := []byte("x509 certificate, not an openpgp packet stream")
+	data := buildTestKeybox(t, []struct {
+		blobType byte
+		packets  []byte
+	}{
+		{blobType: 3, packets: x509}, // type 3 is X.509, which ParseKeybox should ignore
+	})
+
+	got, err := ParseKeybox(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseKeybox failed: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("ParseKeybox should skip non-OpenPGP blobs, got %q", got)
+	}
+}
+
+func TestParseKeyboxMissingMagic(t *testing.T) {
+	if _, err := ParseKeybox(bytes.NewReader(make([]byte, 32))); err == nil {
+		t.Errorf("Expected an error for a file missing the KBXf magic")
+	}
+}
@@ -0,0 +1,87 @@
+package gorjun
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// LoadKeyring reads the public keyring in gpgdir, trying pubring.gpg first and falling
+// back to the GnuPG 2.1+ pubring.kbx keybox. It then attaches a private key to every
+// entity it can find one for - from secring.gpg on older GnuPG installations, or from
+// private-keys-v1.d on 2.1+ ones - leaving entities it can't match as public-key-only.
+// Secret keys are attached still encrypted; use SignToken (or decryptPrivateKey) to unlock them
+func LoadKeyring(gpgdir string) (openpgp.EntityList, error) {
+	keyring, err := loadPublicKeyring(gpgdir)
+	if err != nil {
+		return nil, err
+	}
+	if secring, err := os.Open(gpgdir + "/secring.gpg"); err == nil {
+		defer secring.Close()
+		if legacy, err := openpgp.ReadKeyRing(secring); err == nil {
+			attachLegacyPrivateKeys(keyring, legacy)
+		}
+	} else {
+		attachKeyboxPrivateKeys(keyring, gpgdir)
+	}
+	return keyring, nil
+}
+
+func loadPublicKeyring(gpgdir string) (openpgp.EntityList, error) {
+	if f, err := os.Open(gpgdir + "/pubring.gpg"); err == nil {
+		defer f.Close()
+		keyring, err := openpgp.ReadKeyRing(f)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read public keyring: %v", err)
+		}
+		return keyring, nil
+	}
+
+	f, err := os.Open(gpgdir + "/pubring.kbx")
+	if err != nil {
+		return nil, fmt.Errorf("Can't find pubring.gpg nor pubring.kbx: %v", err)
+	}
+	defer f.Close()
+	packets, err := ParseKeybox(f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse pubring.kbx: %v", err)
+	}
+	keyring, err := openpgp.ReadKeyRing(bytes.NewReader(packets))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read keyring from pubring.kbx: %v", err)
+	}
+	return keyring, nil
+}
+
+// attachLegacyPrivateKeys matches entities from a pre-2.1 secring.gpg onto keyring by
+// fingerprint and copies over their private key
+func attachLegacyPrivateKeys(keyring, secrets openpgp.EntityList) {
+	byFingerprint := make(map[string]*openpgp.Entity, len(secrets))
+	for _, e := range secrets {
+		byFingerprint[fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)] = e
+	}
+	for _, e := range keyring {
+		if secret, ok := byFingerprint[fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)]; ok {
+			e.PrivateKey = secret.PrivateKey
+		}
+	}
+}
+
+// attachKeyboxPrivateKeys looks up each entity's private-keys-v1.d file and attaches the
+// still-encrypted key material, to be decrypted later via decryptPrivateKey. findProtectedKey
+// locates the file by keygrip where possible (RSA) and by matching public key material
+// otherwise (ECC, since Keygrip doesn't implement GnuPG's ECC derivation)
+func attachKeyboxPrivateKeys(keyring openpgp.EntityList, gpgdir string) {
+	for _, e := range keyring {
+		pk, err := findProtectedKey(gpgdir, e.PrimaryKey)
+		if err != nil {
+			continue
+		}
+		priv := &packet.PrivateKey{PublicKey: *e.PrimaryKey, Encrypted: true}
+		setKeyboxSecret(priv, pk)
+		e.PrivateKey = priv
+	}
+}
@@ -0,0 +1,167 @@
+package gorjun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// GorjunGPGSubkey is a subkey attached to a GorjunGPGKey
+type GorjunGPGSubkey struct {
+	KeyID       string `json:"keyId"`
+	Fingerprint string `json:"fingerprint"`
+	CanSign     bool   `json:"canSign"`
+	CanEncrypt  bool   `json:"canEncrypt"`
+}
+
+// GorjunGPGKey is a GPG public key registered against a Gorjun user account
+type GorjunGPGKey struct {
+	KeyID       string            `json:"keyId"`
+	Fingerprint string            `json:"fingerprint"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	ExpiresAt   *time.Time        `json:"expiresAt,omitempty"`
+	CanSign     bool              `json:"canSign"`
+	CanEncrypt  bool              `json:"canEncrypt"`
+	CanCertify  bool              `json:"canCertify"`
+	Identities  []string          `json:"identities"`
+	Subkeys     []GorjunGPGSubkey `json:"subkeys,omitempty"`
+}
+
+// parseGPGKey fills in a GorjunGPGKey from an armored public key block, so the same
+// struct is populated whether the caller is uploading a fresh key or listing existing ones
+func parseGPGKey(armoredKey string) (*GorjunGPGKey, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKey))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse armored key: %v", err)
+	}
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("Armored key did not contain any keys")
+	}
+	entity := keyring[0]
+	key := &GorjunGPGKey{
+		KeyID:       fmt.Sprintf("%X", entity.PrimaryKey.KeyId),
+		Fingerprint: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint),
+		CreatedAt:   entity.PrimaryKey.CreationTime,
+	}
+	for _, ident := range entity.Identities {
+		key.Identities = append(key.Identities, ident.UserId.Id)
+		sig := ident.SelfSignature
+		if sig == nil {
+			continue
+		}
+		if sig.KeyLifetimeSecs != nil {
+			expires := entity.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+			key.ExpiresAt = &expires
+		}
+		if sig.FlagsValid {
+			key.CanSign = key.CanSign || sig.FlagSign
+			key.CanCertify = key.CanCertify || sig.FlagCertify
+			key.CanEncrypt = key.CanEncrypt || sig.FlagEncryptCommunications || sig.FlagEncryptStorage
+		}
+	}
+	for _, sub := range entity.Subkeys {
+		subkey := GorjunGPGSubkey{
+			KeyID:       fmt.Sprintf("%X", sub.PublicKey.KeyId),
+			Fingerprint: fmt.Sprintf("%X", sub.PublicKey.Fingerprint),
+		}
+		if sub.Sig != nil && sub.Sig.FlagsValid {
+			subkey.CanSign = sub.Sig.FlagSign
+			subkey.CanEncrypt = sub.Sig.FlagEncryptCommunications || sub.Sig.FlagEncryptStorage
+		}
+		key.Subkeys = append(key.Subkeys, subkey)
+	}
+	return key, nil
+}
+
+// AddGPGKey registers an armored public key against the authenticated user's account and
+// returns the new key's ID
+func (g *GorjunServer) AddGPGKey(armoredPublicKey string) (string, error) {
+	if _, err := parseGPGKey(armoredPublicKey); err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"key":   {armoredPublicKey},
+		"token": {g.Token},
+	}
+	resp, err := http.Post(fmt.Sprintf("https://%s/kurjun/rest/auth/keys", g.Hostname), "application/x-www-form-urlencoded", bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("Failed to upload GPG key: %v", err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read body from %s: %v", g.Hostname, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to register GPG key: %s", data)
+	}
+	return string(data), nil
+}
+
+// ListGPGKeys returns the GPG public keys currently registered for the authenticated user
+func (g *GorjunServer) ListGPGKeys() ([]GorjunGPGKey, error) {
+	resp, err := http.Get(fmt.Sprintf("https://%s/kurjun/rest/auth/keys?user=%s", g.Hostname, g.Username))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve GPG keys from %s: %v", g.Hostname, err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read body from %s: %v", g.Hostname, err)
+	}
+	var armored []string
+	if err := json.Unmarshal(data, &armored); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal contents from %s: %v", g.Hostname, err)
+	}
+	keys := make([]GorjunGPGKey, 0, len(armored))
+	for _, a := range armored {
+		key, err := parseGPGKey(a)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+// DeleteGPGKey removes a registered GPG key by ID from the authenticated user's account
+func (g *GorjunServer) DeleteGPGKey(keyID string) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("https://%s/kurjun/rest/auth/keys?id=%s&token=%s", g.Hostname, keyID, g.Token), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to remove GPG key [%s]: %s", keyID, err)
+	}
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to remove GPG key: %s", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Can't remove GPG key - HTTP request returned %s code", res.Status)
+	}
+	return nil
+}
+
+// ExportGPGKey fetches the armored public key for keyID, so third parties can verify
+// signatures from that uploader without needing the bucket ACL
+func (g *GorjunServer) ExportGPGKey(keyID string) (string, error) {
+	resp, err := http.Get(fmt.Sprintf("https://%s/kurjun/rest/auth/keys/%s.asc", g.Hostname, keyID))
+	if err != nil {
+		return "", fmt.Errorf("Failed to retrieve GPG key from %s: %v", g.Hostname, err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read body from %s: %v", g.Hostname, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to export GPG key %s: %s", keyID, data)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,159 @@
+package gorjun
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PassphraseProvider supplies the passphrase needed to decrypt a private key without
+// requiring the caller to hold it in plaintext for the lifetime of the process. cacheID
+// identifies the key (its hex-encoded fingerprint), description is shown to the user if
+// the provider is interactive
+type PassphraseProvider interface {
+	GetPassphrase(cacheID, description string) (string, error)
+}
+
+// staticPassphrase implements PassphraseProvider by always returning the same passphrase
+type staticPassphrase string
+
+// StaticPassphrase returns a PassphraseProvider that always returns passphrase. It exists
+// mainly so GorjunServer.Passphrase can be implemented as a thin shim around the provider
+// interface
+func StaticPassphrase(passphrase string) PassphraseProvider {
+	return staticPassphrase(passphrase)
+}
+
+func (s staticPassphrase) GetPassphrase(cacheID, description string) (string, error) {
+	return string(s), nil
+}
+
+// promptTTY implements PassphraseProvider by asking the user directly on the controlling
+// terminal, without echoing what they type
+type promptTTY struct{}
+
+// PromptTTY returns a PassphraseProvider that interactively prompts on /dev/tty
+func PromptTTY() PassphraseProvider {
+	return promptTTY{}
+}
+
+func (promptTTY) GetPassphrase(cacheID, description string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open /dev/tty: %v", err)
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "%s: ", description)
+	pass, err := terminal.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read passphrase: %v", err)
+	}
+	return string(pass), nil
+}
+
+// gpgAgent implements PassphraseProvider by speaking the Assuan protocol to a running
+// gpg-agent over its UNIX domain socket, letting gpg-agent prompt or serve from its own
+// passphrase cache instead of the calling program ever seeing the passphrase logic inline
+type gpgAgent struct {
+	socket string
+}
+
+// GPGAgent returns a PassphraseProvider backed by the gpg-agent listening on socket
+// (typically $(gpgconf --list-dirs agent-socket))
+func GPGAgent(socket string) PassphraseProvider {
+	return &gpgAgent{socket: socket}
+}
+
+func (a *gpgAgent) GetPassphrase(cacheID, description string) (string, error) {
+	conn, err := net.Dial("unix", a.socket)
+	if err != nil {
+		return "", fmt.Errorf("Failed to connect to gpg-agent at %s: %v", a.socket, err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if err := expectAssuanOK(reader); err != nil {
+		return "", fmt.Errorf("gpg-agent greeting failed: %v", err)
+	}
+
+	cmd := fmt.Sprintf("GET_PASSPHRASE %s X X %s\n", cacheID, url.QueryEscape(description))
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", fmt.Errorf("Failed to talk to gpg-agent: %v", err)
+	}
+
+	line, err := readAssuanLine(reader)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "ERR ") {
+		return "", fmt.Errorf("gpg-agent refused to provide the passphrase: %s", line)
+	}
+	if !strings.HasPrefix(line, "D ") {
+		return "", fmt.Errorf("Unexpected gpg-agent response: %s", line)
+	}
+	encoded := strings.TrimPrefix(line, "D ")
+
+	final, err := readAssuanLine(reader)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(final, "OK") {
+		return "", fmt.Errorf("gpg-agent did not confirm the passphrase: %s", final)
+	}
+
+	passphrase, err := decodeAssuanData(encoded)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode gpg-agent response: %v", err)
+	}
+	return string(passphrase), nil
+}
+
+// decodeAssuanData unescapes the payload of an Assuan "D" line. Unlike form-encoded data,
+// Assuan percent-escaping only ever stands for "%", CR or LF - a literal "+" is not an
+// encoded space and must be passed through unchanged, so url.QueryUnescape is the wrong
+// tool here: it would silently turn a "+" in the passphrase into a space
+func decodeAssuanData(s string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			out = append(out, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return nil, fmt.Errorf("truncated %%XX escape at offset %d", i)
+		}
+		b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %%XX escape %q: %v", s[i:i+3], err)
+		}
+		out = append(out, byte(b))
+		i += 2
+	}
+	return out, nil
+}
+
+func expectAssuanOK(r *bufio.Reader) error {
+	line, err := readAssuanLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK") {
+		return fmt.Errorf("unexpected response: %s", line)
+	}
+	return nil
+}
+
+func readAssuanLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Failed to read from gpg-agent: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
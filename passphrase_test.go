@@ -0,0 +1,31 @@
+package gorjun
+
+import "testing"
+
+func TestDecodeAssuanDataPreservesPlus(t *testing.T) {
+	// unlike url.QueryUnescape, a literal "+" in Assuan D-line data is not an encoded
+	// space and must round-trip unchanged
+	got, err := decodeAssuanData("correct+horse+battery")
+	if err != nil {
+		t.Fatalf("decodeAssuanData failed: %v", err)
+	}
+	if string(got) != "correct+horse+battery" {
+		t.Errorf("decodeAssuanData = %q, want %q", got, "correct+horse+battery")
+	}
+}
+
+func TestDecodeAssuanDataUnescapesPercent(t *testing.T) {
+	got, err := decodeAssuanData("100%25+done%0Anext")
+	if err != nil {
+		t.Fatalf("decodeAssuanData failed: %v", err)
+	}
+	if string(got) != "100%+done\nnext" {
+		t.Errorf("decodeAssuanData = %q, want %q", got, "100%+done\nnext")
+	}
+}
+
+func TestDecodeAssuanDataTruncatedEscape(t *testing.T) {
+	if _, err := decodeAssuanData("abc%2"); err == nil {
+		t.Errorf("Expected an error for a truncated %%XX escape")
+	}
+}
@@ -0,0 +1,341 @@
+package gorjun
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Keygrip computes the libgcrypt keygrip for pub, the identifier gpg-agent and
+// private-keys-v1.d use to name a key's secret material. Only RSA is currently supported;
+// GnuPG's ECC keygrip uses a different, curve-dependent derivation. findProtectedKey falls
+// back to matching on public key material when this returns an error for non-RSA keys
+func Keygrip(pub *packet.PublicKey) (string, error) {
+	rsaKey, ok := pub.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("keygrip computation is only supported for RSA keys")
+	}
+	n := rsaKey.N.Bytes()
+	if len(n) > 0 && n[0]&0x80 != 0 {
+		// libgcrypt prefixes the MPI with a leading zero byte whenever the high bit of
+		// the first byte is set, so the value is never mistaken for a negative number
+		n = append([]byte{0}, n...)
+	}
+	sum := sha1.Sum(n)
+	return strings.ToUpper(hex.EncodeToString(sum[:])), nil
+}
+
+// eccCurves maps the curve names GnuPG writes into private-keys-v1.d (ecc (curve ...))
+// nodes to their Go elliptic.Curve. Only the NIST curves are listed here - Ed25519 and
+// Curve25519 (cv25519) use EdDSA/ECDH respectively rather than ECDSA, and decrypt
+// rejects them with an error
+var eccCurves = map[string]elliptic.Curve{
+	"NIST P-256": elliptic.P256(),
+	"nistp256":   elliptic.P256(),
+	"NIST P-384": elliptic.P384(),
+	"nistp384":   elliptic.P384(),
+	"NIST P-521": elliptic.P521(),
+	"nistp521":   elliptic.P521(),
+}
+
+// protectedKey is the parsed (but still encrypted) contents of a
+// private-keys-v1.d/<keygrip>.key file
+type protectedKey struct {
+	algo string // "rsa" or "ecc"
+
+	// rsa
+	n, e *big.Int
+
+	// ecc
+	curve string
+	q     []byte
+
+	salt      []byte
+	count     int
+	iv        []byte
+	encrypted []byte
+}
+
+// matchesPublicKey reports whether pk's public key parameters are the ones backing pub.
+// findProtectedKey uses this to identify pk's file by content instead of by keygrip when
+// the keygrip can't be computed for pub's algorithm
+func (pk *protectedKey) matchesPublicKey(pub *packet.PublicKey) bool {
+	switch pk.algo {
+	case "rsa":
+		rsaPub, ok := pub.PublicKey.(*rsa.PublicKey)
+		return ok && pk.n.Cmp(rsaPub.N) == 0 && pk.e.Int64() == int64(rsaPub.E)
+	case "ecc":
+		ecdsaPub, ok := pub.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		curve, known := eccCurves[pk.curve]
+		if !known || curve.Params().Name != ecdsaPub.Curve.Params().Name {
+			return false
+		}
+		return bytes.Equal(pk.q, elliptic.Marshal(ecdsaPub.Curve, ecdsaPub.X, ecdsaPub.Y))
+	default:
+		return false
+	}
+}
+
+// loadProtectedKey reads and parses the private-keys-v1.d file for keygrip. Only the
+// "openpgp-s2k3-sha1-aes-cbc" protection mode (GnuPG's default) is understood, for either
+// an "rsa" or an "ecc" key
+func loadProtectedKey(gpgdir, keygrip string) (*protectedKey, error) {
+	path := filepath.Join(gpgdir, "private-keys-v1.d", strings.ToUpper(keygrip)+".key")
+	return parseProtectedKeyFile(path)
+}
+
+// findProtectedKey locates pub's private-keys-v1.d file and parses it. It first tries the
+// fast path of computing pub's keygrip directly, since the file is named after it - but
+// Keygrip only implements GnuPG's RSA derivation, so for any other algorithm (or if the
+// keygrip-named file doesn't exist) it falls back to scanning every file in
+// private-keys-v1.d and matching on public key material instead, which works regardless
+// of algorithm
+func findProtectedKey(gpgdir string, pub *packet.PublicKey) (*protectedKey, error) {
+	if grip, err := Keygrip(pub); err == nil {
+		if pk, err := loadProtectedKey(gpgdir, grip); err == nil {
+			return pk, nil
+		}
+	}
+
+	dir := filepath.Join(gpgdir, "private-keys-v1.d")
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %v", dir, err)
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".key") {
+			continue
+		}
+		pk, err := parseProtectedKeyFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			continue
+		}
+		if pk.matchesPublicKey(pub) {
+			return pk, nil
+		}
+	}
+	return nil, fmt.Errorf("no file in %s matches the given public key", dir)
+}
+
+func parseProtectedKeyFile(path string) (*protectedKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %v", path, err)
+	}
+	root, err := parseSexpr(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	if !root.isList || len(root.list) < 2 || root.list[0].text() != "protected-private-key" {
+		return nil, fmt.Errorf("%s is not a protected-private-key", path)
+	}
+	algoNode := root.list[1]
+	if !algoNode.isList || len(algoNode.list) == 0 {
+		return nil, fmt.Errorf("%s has no key algorithm", path)
+	}
+
+	pk := &protectedKey{algo: algoNode.list[0].text()}
+	switch pk.algo {
+	case "rsa":
+		nNode, eNode := algoNode.child("n"), algoNode.child("e")
+		if nNode == nil || eNode == nil || len(nNode.list) < 2 || len(eNode.list) < 2 {
+			return nil, fmt.Errorf("%s is missing public key parameters", path)
+		}
+		pk.n = new(big.Int).SetBytes(nNode.list[1].data)
+		pk.e = new(big.Int).SetBytes(eNode.list[1].data)
+	case "ecc":
+		curveNode, qNode := algoNode.child("curve"), algoNode.child("q")
+		if curveNode == nil || qNode == nil || len(curveNode.list) < 2 || len(qNode.list) < 2 {
+			return nil, fmt.Errorf("%s is missing public key parameters", path)
+		}
+		pk.curve = curveNode.list[1].text()
+		pk.q = qNode.list[1].data
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q in %s", pk.algo, path)
+	}
+
+	protNode := algoNode.child("protected")
+	if protNode == nil || len(protNode.list) < 4 {
+		return nil, fmt.Errorf("%s is missing protection parameters", path)
+	}
+	if mode := protNode.list[1].text(); mode != "openpgp-s2k3-sha1-aes-cbc" {
+		return nil, fmt.Errorf("unsupported protection mode %q in %s", mode, path)
+	}
+	s2kNode := protNode.list[2]
+	if !s2kNode.isList || len(s2kNode.list) < 2 {
+		return nil, fmt.Errorf("%s has a malformed s2k specifier", path)
+	}
+	s2kParams := s2kNode.list[0]
+	if !s2kParams.isList || len(s2kParams.list) < 3 || s2kParams.list[0].text() != "sha1" {
+		return nil, fmt.Errorf("%s uses an unsupported s2k hash", path)
+	}
+	count, err := strconv.Atoi(s2kParams.list[2].text())
+	if err != nil {
+		return nil, fmt.Errorf("%s has an invalid s2k count: %v", path, err)
+	}
+
+	pk.salt = s2kParams.list[1].data
+	pk.count = count
+	pk.iv = s2kNode.list[1].data
+	pk.encrypted = protNode.list[3].data
+	return pk, nil
+}
+
+// deriveS2K implements RFC 4880's iterated & salted S2K (the only mode GnuPG uses to
+// protect on-disk secret keys), expanding across multiple SHA-1 contexts if keyLen is
+// larger than a single digest
+func deriveS2K(passphrase string, salt []byte, count, keyLen int) []byte {
+	var result []byte
+	seed := append(append([]byte{}, salt...), []byte(passphrase)...)
+	if count < len(seed) {
+		// the whole salt+passphrase is always hashed, even past the octet count
+		count = len(seed)
+	}
+	for context := 0; len(result) < keyLen; context++ {
+		h := sha1.New()
+		h.Write(bytes.Repeat([]byte{0}, context))
+		written := 0
+		for written < count {
+			remaining := count - written
+			if remaining >= len(seed) {
+				h.Write(seed)
+				written += len(seed)
+			} else {
+				h.Write(seed[:remaining])
+				written += remaining
+			}
+		}
+		result = append(result, h.Sum(nil)...)
+	}
+	return result[:keyLen]
+}
+
+// decrypt derives the CEK from passphrase via the embedded S2K, decrypts the protected
+// key material and reconstructs an *packet.PrivateKey. creationTime must be the original
+// public key's creation time (not the decryption time) since it is part of what the
+// OpenPGP fingerprint/key ID are computed over - using the wrong value here produces a
+// private key whose key ID doesn't match the real public key. It returns
+// errors.ErrKeyIncorrect if the passphrase was wrong (the decrypted checksum does not match)
+func (pk *protectedKey) decrypt(passphrase string, creationTime time.Time) (*packet.PrivateKey, error) {
+	key := deriveS2K(passphrase, pk.salt, pk.count, 16)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create AES cipher: %v", err)
+	}
+	if len(pk.iv) != block.BlockSize() || len(pk.encrypted) == 0 || len(pk.encrypted)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("protected key material is malformed")
+	}
+
+	cleartext := make([]byte, len(pk.encrypted))
+	cipher.NewCBCDecrypter(block, pk.iv).CryptBlocks(cleartext, pk.encrypted)
+
+	if len(cleartext) < sha1.Size {
+		return nil, fmt.Errorf("decrypted key material is too short")
+	}
+	payload, checksum := cleartext[:len(cleartext)-sha1.Size], cleartext[len(cleartext)-sha1.Size:]
+	sum := sha1.Sum(payload)
+	if !bytes.Equal(sum[:], checksum) {
+		return nil, errors.ErrKeyIncorrect
+	}
+
+	params, err := parseSexpr(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse decrypted key material: %v", err)
+	}
+
+	switch pk.algo {
+	case "rsa":
+		d, p, q := findMPI(params, "d"), findMPI(params, "p"), findMPI(params, "q")
+		if d == nil || p == nil || q == nil {
+			return nil, fmt.Errorf("decrypted key material is missing rsa parameters")
+		}
+		priv := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: pk.n, E: int(pk.e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		priv.Precompute()
+		return packet.NewRSAPrivateKey(creationTime, priv), nil
+	case "ecc":
+		curve, ok := eccCurves[pk.curve]
+		if !ok {
+			// Ed25519 (signing) and Curve25519/cv25519 (encryption) keys land here -
+			// GnuPG's default curve for new keys since 2.1. Upstream
+			// golang.org/x/crypto/openpgp/packet has no EdDSA private key packet
+			// constructor, so there is no way to reconstruct one of these as a
+			// packet.PrivateKey today. TODO(chunk0-4): revisit once packet gains
+			// EdDSA support, or vendor a fork that has it
+			return nil, fmt.Errorf("unsupported ecc curve %q (only NIST P-256/P-384/P-521 are supported; Ed25519/cv25519 are not yet)", pk.curve)
+		}
+		d := findMPI(params, "d")
+		if d == nil {
+			return nil, fmt.Errorf("decrypted key material is missing ecc parameters")
+		}
+		x, y := elliptic.Unmarshal(curve, pk.q)
+		if x == nil {
+			return nil, fmt.Errorf("ecc public key point is malformed")
+		}
+		priv := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+			D:         d,
+		}
+		return packet.NewECDSAPrivateKey(creationTime, priv), nil
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", pk.algo)
+	}
+}
+
+// findMPI looks up a single-letter parameter (e.g. "d", "p", "q") inside the canonical
+// S-expression GnuPG wraps decrypted RSA or ECC secret material in
+func findMPI(params *sexprNode, tag string) *big.Int {
+	if !params.isList {
+		return nil
+	}
+	for _, c := range params.list {
+		if c.isList && len(c.list) >= 2 && c.list[0].text() == tag {
+			return new(big.Int).SetBytes(c.list[1].data)
+		}
+	}
+	return nil
+}
+
+// keyboxSecrets associates a *packet.PrivateKey produced by attachKeyboxPrivateKeys with
+// the still-encrypted material it was built from, so decryptPrivateKey can decrypt it
+// lazily once it has a passphrase. packet.PrivateKey has no exported way to carry this
+// extra state itself
+var (
+	keyboxSecretsMu sync.Mutex
+	keyboxSecrets   = map[*packet.PrivateKey]*protectedKey{}
+)
+
+func setKeyboxSecret(priv *packet.PrivateKey, pk *protectedKey) {
+	keyboxSecretsMu.Lock()
+	defer keyboxSecretsMu.Unlock()
+	keyboxSecrets[priv] = pk
+}
+
+func getKeyboxSecret(priv *packet.PrivateKey) (*protectedKey, bool) {
+	keyboxSecretsMu.Lock()
+	defer keyboxSecretsMu.Unlock()
+	pk, ok := keyboxSecrets[priv]
+	return pk, ok
+}
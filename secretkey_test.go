@@ -0,0 +1,171 @@
+package gorjun
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// canonicalAtom renders data as a canonical S-expression atom: "<length>:<raw bytes>"
+func canonicalAtom(data []byte) []byte {
+	return append([]byte(fmt.Sprintf("%d:", len(data))), data...)
+}
+
+// canonicalList concatenates its children inside a parenthesized canonical S-expression list
+func canonicalList(children ...[]byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte('(')
+	for _, c := range children {
+		b.Write(c)
+	}
+	b.WriteByte(')')
+	return b.Bytes()
+}
+
+// buildProtectedRSAKey encrypts priv the way GnuPG protects an "rsa" private-keys-v1.d
+// entry - as the canonical S-expression "(rsa (d ...) (p ...) (q ...))", SHA-1 checksummed
+// and then AES-CBC encrypted under deriveS2K(passphrase, salt, count, 16) - and returns the
+// resulting protectedKey, ready to hand to decrypt
+func buildProtectedRSAKey(t *testing.T, priv *rsa.PrivateKey, passphrase string, salt []byte, count int) *protectedKey {
+	t.Helper()
+
+	payload := canonicalList(
+		canonicalAtom([]byte("rsa")),
+		canonicalList(canonicalAtom([]byte("d")), canonicalAtom(priv.D.Bytes())),
+		canonicalList(canonicalAtom([]byte("p")), canonicalAtom(priv.Primes[0].Bytes())),
+		canonicalList(canonicalAtom([]byte("q")), canonicalAtom(priv.Primes[1].Bytes())),
+	)
+	// pad with spare bytes after the closing paren so payload+checksum lands on a block
+	// boundary - parseSexpr stops at the first complete node, so trailing bytes are ignored
+	padded := len(payload) + sha1.Size
+	payload = append(payload, make([]byte, (aes.BlockSize-padded%aes.BlockSize)%aes.BlockSize)...)
+	checksum := sha1.Sum(payload)
+	cleartext := append(payload, checksum[:]...)
+
+	key := deriveS2K(passphrase, salt, count, 16)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("Failed to create AES cipher: %v", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatalf("Failed to generate iv: %v", err)
+	}
+	encrypted := make([]byte, len(cleartext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, cleartext)
+
+	return &protectedKey{
+		algo:      "rsa",
+		n:         priv.PublicKey.N,
+		e:         big.NewInt(int64(priv.PublicKey.E)),
+		salt:      salt,
+		count:     count,
+		iv:        iv,
+		encrypted: encrypted,
+	}
+}
+
+func TestProtectedKeyDecryptRoundTrip(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	pk := buildProtectedRSAKey(t, priv, "correct horse", []byte("saltsalt"), 96)
+
+	creationTime := time.Unix(1700000000, 0)
+	result, err := pk.decrypt("correct horse", creationTime)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if result.Encrypted {
+		t.Errorf("decrypt returned a key still marked as encrypted")
+	}
+
+	rsaPriv, ok := result.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("decrypt returned a %T, want *rsa.PrivateKey", result.PrivateKey)
+	}
+	if rsaPriv.N.Cmp(priv.N) != 0 || rsaPriv.E != priv.E || rsaPriv.D.Cmp(priv.D) != 0 {
+		t.Errorf("decrypt did not reconstruct the original key parameters")
+	}
+
+	digest := sha1.Sum([]byte("message to sign"))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaPriv, 0, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign with decrypted key: %v", err)
+	}
+	if err := rsa.VerifyPKCS1v15(&priv.PublicKey, 0, digest[:], sig); err != nil {
+		t.Errorf("Signature from decrypted key does not verify against the original public key: %v", err)
+	}
+}
+
+func TestProtectedKeyDecryptWrongPassphrase(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	pk := buildProtectedRSAKey(t, priv, "correct horse", []byte("saltsalt"), 96)
+
+	if _, err := pk.decrypt("wrong passphrase", time.Unix(1700000000, 0)); err != errors.ErrKeyIncorrect {
+		t.Errorf("decrypt with a wrong passphrase returned %v, want errors.ErrKeyIncorrect", err)
+	}
+}
+
+func keygripOf(t *testing.T, n *big.Int) string {
+	t.Helper()
+	pub := packet.NewRSAPublicKey(time.Unix(0, 0), &rsa.PublicKey{N: n, E: 65537})
+	grip, err := Keygrip(pub)
+	if err != nil {
+		t.Fatalf("Keygrip failed: %v", err)
+	}
+	return grip
+}
+
+func TestKeygripMatchesSHA1OfN(t *testing.T) {
+	// the high bit of the first byte is clear, so libgcrypt hashes n's bytes unmodified
+	n := new(big.Int).SetBytes([]byte{0x12, 0x34, 0x56})
+	sum := sha1.Sum(n.Bytes())
+	want := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	got := keygripOf(t, n)
+	if got != want {
+		t.Errorf("Keygrip = %s, want %s", got, want)
+	}
+}
+
+func TestKeygripPrependsZeroWhenHighBitSet(t *testing.T) {
+	// the high bit of the first byte is set, so libgcrypt hashes a leading zero byte
+	// prepended to n's bytes, not n's bytes alone
+	n := new(big.Int).SetBytes([]byte{0xff, 0x00, 0x01})
+	sum := sha1.Sum(append([]byte{0}, n.Bytes()...))
+	want := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	got := keygripOf(t, n)
+	if got != want {
+		t.Errorf("Keygrip = %s, want %s", got, want)
+	}
+}
+
+func TestDeriveS2KLength(t *testing.T) {
+	key := deriveS2K("passphrase", []byte("salt8byt"), 1024, 32)
+	if len(key) != 32 {
+		t.Errorf("deriveS2K returned %d bytes, want 32", len(key))
+	}
+	// deriving again with the same inputs must be deterministic
+	again := deriveS2K("passphrase", []byte("salt8byt"), 1024, 32)
+	if string(key) != string(again) {
+		t.Errorf("deriveS2K is not deterministic for identical inputs")
+	}
+}
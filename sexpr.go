@@ -0,0 +1,155 @@
+package gorjun
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sexprNode is a node of the S-expressions GnuPG uses both for the "advanced" format
+// (parenthesized, #hex#-encoded atoms) found in private-keys-v1.d/*.key files and for the
+// canonical (length-prefixed binary) format used for the cleartext payload once a
+// protected key has been decrypted
+type sexprNode struct {
+	isList bool
+	list   []*sexprNode
+	symbol string
+	data   []byte
+}
+
+// text returns the atom's value regardless of whether it was written as a bareword, a
+// quoted string or a binary atom
+func (n *sexprNode) text() string {
+	if n.symbol != "" {
+		return n.symbol
+	}
+	return string(n.data)
+}
+
+// child returns the first list element of n that is itself a list whose first atom is tag,
+// e.g. child(n, "protected") on "(rsa (n ...) (protected ...))" returns the "(protected ...)" node
+func (n *sexprNode) child(tag string) *sexprNode {
+	if !n.isList {
+		return nil
+	}
+	for _, c := range n.list {
+		if c.isList && len(c.list) > 0 && c.list[0].text() == tag {
+			return c
+		}
+	}
+	return nil
+}
+
+func parseSexpr(data []byte) (*sexprNode, error) {
+	p := &sexprParser{data: data}
+	p.skipSpace()
+	return p.parseNode()
+}
+
+type sexprParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *sexprParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\n', '\t', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *sexprParser) parseNode() (*sexprNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("unexpected end of S-expression")
+	}
+	switch {
+	case p.data[p.pos] == '(':
+		p.pos++
+		n := &sexprNode{isList: true}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return nil, fmt.Errorf("unterminated S-expression list")
+			}
+			if p.data[p.pos] == ')' {
+				p.pos++
+				return n, nil
+			}
+			child, err := p.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, child)
+		}
+	case p.data[p.pos] == '#':
+		end := bytes.IndexByte(p.data[p.pos+1:], '#')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated hex atom in S-expression")
+		}
+		raw := string(p.data[p.pos+1 : p.pos+1+end])
+		raw = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\n' || r == '\t' || r == '\r' {
+				return -1
+			}
+			return r
+		}, raw)
+		decoded, err := hex.DecodeString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex atom in S-expression: %v", err)
+		}
+		p.pos += 1 + end + 1
+		return &sexprNode{data: decoded}, nil
+	case p.data[p.pos] == '"':
+		end := bytes.IndexByte(p.data[p.pos+1:], '"')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string atom in S-expression")
+		}
+		s := string(p.data[p.pos+1 : p.pos+1+end])
+		p.pos += 1 + end + 1
+		return &sexprNode{symbol: s}, nil
+	case isSexprDigit(p.data[p.pos]):
+		// canonical form: "<length>:<raw bytes>"
+		start := p.pos
+		for p.pos < len(p.data) && isSexprDigit(p.data[p.pos]) {
+			p.pos++
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == ':' {
+			n, err := strconv.Atoi(string(p.data[start:p.pos]))
+			if err == nil && p.pos+1+n <= len(p.data) {
+				raw := p.data[p.pos+1 : p.pos+1+n]
+				p.pos += 1 + n
+				return &sexprNode{data: raw}, nil
+			}
+		}
+		p.pos = start
+		return p.parseBareword()
+	default:
+		return p.parseBareword()
+	}
+}
+
+func (p *sexprParser) parseBareword() (*sexprNode, error) {
+	start := p.pos
+	for p.pos < len(p.data) && !isSexprDelim(p.data[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, fmt.Errorf("unexpected character %q in S-expression", p.data[p.pos])
+	}
+	return &sexprNode{symbol: string(p.data[start:p.pos])}, nil
+}
+
+func isSexprDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isSexprDelim(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\t' || b == '\r' || b == '(' || b == ')'
+}
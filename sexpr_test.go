@@ -0,0 +1,46 @@
+package gorjun
+
+import (
+	"testing"
+)
+
+func TestParseSexprCanonical(t *testing.T) {
+	root, err := parseSexpr([]byte("(3:rsa(1:n3:abc)(1:e1:e))"))
+	if err != nil {
+		t.Fatalf("Failed to parse canonical S-expression: %v", err)
+	}
+	if !root.isList || len(root.list) != 3 {
+		t.Fatalf("Unexpected root: %+v", root)
+	}
+	if root.list[0].text() != "rsa" {
+		t.Errorf("Unexpected algorithm atom: %q", root.list[0].text())
+	}
+	n := root.child("n")
+	if n == nil || len(n.list) < 2 || string(n.list[1].data) != "abc" {
+		t.Errorf("Unexpected n node: %+v", n)
+	}
+}
+
+func TestParseSexprAdvanced(t *testing.T) {
+	root, err := parseSexpr([]byte("(protected-private-key (rsa (n #deadbeef#) (e #010001#)))"))
+	if err != nil {
+		t.Fatalf("Failed to parse advanced S-expression: %v", err)
+	}
+	if root.list[0].text() != "protected-private-key" {
+		t.Errorf("Unexpected root tag: %q", root.list[0].text())
+	}
+	rsaNode := root.list[1]
+	n := rsaNode.child("n")
+	if n == nil || len(n.list) < 2 {
+		t.Fatalf("Unexpected n node: %+v", n)
+	}
+	if string(n.list[1].data) != "\xde\xad\xbe\xef" {
+		t.Errorf("Unexpected decoded hex atom: %x", n.list[1].data)
+	}
+}
+
+func TestParseSexprUnterminated(t *testing.T) {
+	if _, err := parseSexpr([]byte("(rsa (n #dead")); err == nil {
+		t.Errorf("Expected an error for an unterminated S-expression")
+	}
+}
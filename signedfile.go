@@ -0,0 +1,126 @@
+package gorjun
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// UploadSignedFile uploads filename the same way UploadFile does, plus an ASCII-armored
+// detached signature over its contents sent as a second multipart field named "signature"
+// (stored server-side as a sibling "<name>.asc"). This gives downstream consumers a way
+// to pin uploaders by fingerprint with VerifyFileByID instead of trusting the bucket ACL
+func (g *GorjunServer) UploadSignedFile(filename string) (string, error) {
+	privateKey, err := g.loadSigningKey()
+	if err != nil {
+		return "", fmt.Errorf("Failed to load signing key: %v", err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, privateKey, f, nil); err != nil {
+		return "", fmt.Errorf("Failed to sign %s: %v", filename, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("Failed to rewind %s: %v", filename, err)
+	}
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	fw, err := w.CreateFormFile("file", filepath.Base(filename))
+	if err != nil {
+		return "", fmt.Errorf("Failed to create file form: %v", err)
+	}
+	if _, err := io.Copy(fw, f); err != nil {
+		return "", fmt.Errorf("Failed to copy file contents: %v", err)
+	}
+	if err := writeMultipartFile(w, "signature", filepath.Base(filename)+".asc", sig.Bytes()); err != nil {
+		return "", err
+	}
+	if fw, err = w.CreateFormField("token"); err != nil {
+		return "", fmt.Errorf("Failed to create token form field: %v", err)
+	}
+	if _, err = fw.Write([]byte(g.Token)); err != nil {
+		return "", fmt.Errorf("Failed to write token: %v", err)
+	}
+	w.Close()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s/kurjun/rest/raw/upload", g.Hostname), &b)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to execute HTTP request: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Upload failed. Server returned %s error", res.Status)
+	}
+	response, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read response body: %v", err)
+	}
+	return string(response), nil
+}
+
+// VerifyFileByID downloads the file with the given ID and its "<name>.asc" detached
+// signature, and checks the signature against keyring, returning the signing Entity on
+// success
+func (g *GorjunServer) VerifyFileByID(ID string, keyring openpgp.EntityList) (*openpgp.Entity, error) {
+	file, err := g.getFileByID(ID)
+	if err != nil {
+		return nil, err
+	}
+	sigFiles, err := g.GetFileByName(file.Name + ".asc")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find signature for %s: %v", file.Name, err)
+	}
+	if len(sigFiles) == 0 {
+		return nil, fmt.Errorf("Signature for %s was not found", file.Name)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "libgorjun-verify")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := g.DownloadFileByID(ID, tmpDir); err != nil {
+		return nil, fmt.Errorf("Failed to download %s: %v", file.Name, err)
+	}
+	if err := g.DownloadFileByID(sigFiles[len(sigFiles)-1].Id, tmpDir); err != nil {
+		return nil, fmt.Errorf("Failed to download signature for %s: %v", file.Name, err)
+	}
+
+	data, err := os.Open(filepath.Join(tmpDir, file.Name))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open downloaded file: %v", err)
+	}
+	defer data.Close()
+	sig, err := os.Open(filepath.Join(tmpDir, file.Name+".asc"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open downloaded signature: %v", err)
+	}
+	defer sig.Close()
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(keyring, data, sig)
+	if err != nil {
+		return nil, fmt.Errorf("Signature verification failed for %s: %v", file.Name, err)
+	}
+	return entity, nil
+}